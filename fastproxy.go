@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fastProxyIdleTimeout bounds how long a pooled connection may sit unused
+// before it is considered stale and discarded instead of reused.
+const fastProxyIdleTimeout = 90 * time.Second
+
+// pooledConn is a persistent upstream connection kept ready for reuse,
+// along with the buffered reader/writer wrapped around it.
+type pooledConn struct {
+	net.Conn
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	idleAt time.Time
+}
+
+// connPool is a bounded LIFO stack of persistent connections to a single
+// (scheme, host) upstream. Reusing the most recently released connection
+// keeps a small, hot working set alive under steady load instead of
+// round-robining across every connection ever opened.
+type connPool struct {
+	scheme string
+	host   string
+	tlsCfg *tls.Config
+
+	mu    sync.Mutex
+	conns []*pooledConn
+	max   int
+}
+
+func newConnPool(scheme, host string, tlsCfg *tls.Config, max int) *connPool {
+	return &connPool{scheme: scheme, host: host, tlsCfg: tlsCfg, max: max}
+}
+
+// get pops a live connection from the pool, discarding any that have gone
+// idle for too long, or dials a new one if the pool is empty.
+func (p *connPool) get() (*pooledConn, error) {
+	p.mu.Lock()
+	for len(p.conns) > 0 {
+		c := p.conns[len(p.conns)-1]
+		p.conns = p.conns[:len(p.conns)-1]
+		p.mu.Unlock()
+		if time.Since(c.idleAt) > fastProxyIdleTimeout {
+			c.Close()
+			p.mu.Lock()
+			continue
+		}
+		return c, nil
+	}
+	p.mu.Unlock()
+	return p.dial()
+}
+
+func (p *connPool) dial() (*pooledConn, error) {
+	raw, err := net.DialTimeout("tcp", p.host, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if p.scheme == "https" {
+		tc := tls.Client(raw, p.tlsCfg)
+		if err := tc.Handshake(); err != nil {
+			raw.Close()
+			return nil, err
+		}
+		raw = tc
+	}
+	return &pooledConn{Conn: raw, br: bufio.NewReader(raw), bw: bufio.NewWriter(raw)}, nil
+}
+
+// put returns c to the pool for reuse, or closes it if the pool is full.
+func (p *connPool) put(c *pooledConn) {
+	c.idleAt = time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.conns) >= p.max {
+		c.Close()
+		return
+	}
+	p.conns = append(p.conns, c)
+}
+
+// fastProxyBufPool pools the []byte buffers used to stream response bodies,
+// avoiding a per-request allocation for the common case.
+var fastProxyBufPool = sync.Pool{
+	New: func() interface{} { return make([]byte, 32*1024) },
+}
+
+// fastProxy is a hand-rolled HTTP/1.1 reverse proxy aimed at high-RPS
+// traffic to a single fixed upstream. It keeps a pool of persistent
+// connections per (scheme, host) instead of going through the stdlib
+// http.Transport, trading generality for fewer per-request allocations.
+type fastProxy struct {
+	target *url.URL
+	pools  sync.Map // map[string]*connPool, keyed by scheme+"://"+host
+	tlsCfg *tls.Config
+
+	hostHeader string
+	maxConns   int
+}
+
+func newFastProxy(target *url.URL, hostHeader string, insecure bool, maxConns int) *fastProxy {
+	if maxConns <= 0 {
+		maxConns = 64
+	}
+	return &fastProxy{
+		target:     target,
+		hostHeader: hostHeader,
+		maxConns:   maxConns,
+		tlsCfg:     &tls.Config{InsecureSkipVerify: insecure, ServerName: hostHeader},
+	}
+}
+
+func (f *fastProxy) poolFor(scheme, host string) *connPool {
+	key := scheme + "://" + host
+	if p, ok := f.pools.Load(key); ok {
+		return p.(*connPool)
+	}
+	p := newConnPool(scheme, host, f.tlsCfg, f.maxConns)
+	actual, _ := f.pools.LoadOrStore(key, p)
+	return actual.(*connPool)
+}
+
+// ServeHTTP implements http.Handler by rewriting req to the fixed target
+// and round-tripping it over a pooled connection.
+func (f *fastProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host := f.target.Host
+	if !strings.Contains(host, ":") {
+		if f.target.Scheme == "https" {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+	pool := f.poolFor(f.target.Scheme, host)
+
+	outHost := f.target.Host
+	if f.hostHeader != "" {
+		outHost = f.hostHeader
+	}
+	req.Host = outHost
+	req.URL.Host = f.target.Host
+	req.URL.Scheme = f.target.Scheme
+	req.RequestURI = ""
+
+	// req.Write always reads and closes req.Body, so a pooled connection
+	// that turns out to be stale on the first attempt would otherwise
+	// retry with an already-drained body. Buffer it up front so each
+	// attempt gets its own fresh reader.
+	var bodyBytes []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			http.Error(w, "failed to read request body: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		bodyBytes = b
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if bodyBytes != nil {
+			req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		}
+
+		conn, err := pool.get()
+		if err != nil {
+			http.Error(w, "upstream dial failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		if err := req.Write(conn.bw); err != nil {
+			conn.Close()
+			continue
+		}
+		if err := conn.bw.Flush(); err != nil {
+			conn.Close()
+			continue
+		}
+
+		resp, err := readFinalResponse(conn.br, req)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+
+		if isUpgrade(req, resp) {
+			f.hijackAndCopy(w, conn, resp)
+			return
+		}
+
+		for k, vs := range resp.Header {
+			for _, v := range vs {
+				w.Header().Add(k, v)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+
+		buf := fastProxyBufPool.Get().([]byte)
+		_, copyErr := io.CopyBuffer(w, resp.Body, buf)
+		fastProxyBufPool.Put(buf)
+		resp.Body.Close()
+
+		keepAlive := !resp.Close && !req.Close && copyErr == nil
+		if keepAlive {
+			pool.put(conn)
+		} else {
+			conn.Close()
+		}
+		return
+	}
+
+	http.Error(w, "upstream connection failed", http.StatusBadGateway)
+}
+
+// readFinalResponse reads resp, discarding any interim 1xx responses (e.g.
+// a 100 Continue sent in reply to an Expect: 100-continue request) along
+// the way. http.ReadResponse has no concept of interim responses on its
+// own, so without this a 100 Continue would be mistaken for the final
+// response and its real status/body would never be read.
+func readFinalResponse(br *bufio.Reader, req *http.Request) (*http.Response, error) {
+	for {
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// isUpgrade reports whether resp is a successful protocol-switch response,
+// e.g. a websocket handshake, that must be handed off as a raw byte stream.
+func isUpgrade(req *http.Request, resp *http.Response) bool {
+	return resp.StatusCode == http.StatusSwitchingProtocols &&
+		strings.EqualFold(req.Header.Get("Connection"), "upgrade")
+}
+
+// hijackAndCopy takes over the client connection and pipes bytes between it
+// and the upstream conn in both directions. The upstream conn is never
+// returned to the pool since its lifetime is now tied to the hijacked
+// client connection.
+func (f *fastProxy) hijackAndCopy(w http.ResponseWriter, upstream *pooledConn, resp *http.Response) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "upgrade not supported", http.StatusInternalServerError)
+		upstream.Close()
+		return
+	}
+	client, brw, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if err := resp.Write(brw); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+	if err := brw.Flush(); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	// hj.Hijack's bufio.Reader may already hold client bytes read in the
+	// same syscall as the Upgrade request, and upstream.br may likewise
+	// already hold upstream bytes read past the response headers. Copy
+	// through both buffered readers instead of the raw conns so neither
+	// set of already-buffered bytes is dropped.
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, brw)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream.br)
+		done <- struct{}{}
+	}()
+	<-done
+	client.Close()
+	upstream.Close()
+}
+
+// runFastProxy starts a listener serving a fastProxy in front of target.
+func runFastProxy(listenAddr string, target *url.URL, hostHeader string, insecure bool) error {
+	fp := newFastProxy(target, hostHeader, insecure, 64)
+	log.Printf("Starting fast reverse proxy server on %s, forwarding to %s", listenAddr, target)
+	return http.ListenAndServe(listenAddr, fp)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeConn is a minimal net.Conn good enough to exercise pool bookkeeping
+// without opening a real socket.
+type fakeConn struct {
+	net.Conn
+	closed bool
+}
+
+func (f *fakeConn) Close() error {
+	f.closed = true
+	return nil
+}
+
+func newTestPooledConn() (*pooledConn, *fakeConn) {
+	fc := &fakeConn{}
+	return &pooledConn{Conn: fc, br: bufio.NewReader(fc), bw: bufio.NewWriter(fc)}, fc
+}
+
+func TestConnPoolPutGetReusesMostRecent(t *testing.T) {
+	p := &connPool{max: 2}
+
+	c1, _ := newTestPooledConn()
+	c2, _ := newTestPooledConn()
+	p.put(c1)
+	p.put(c2)
+
+	got, err := p.get()
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if got != c2 {
+		t.Error("get() should return the most recently put connection (LIFO)")
+	}
+}
+
+func TestConnPoolPutDiscardsWhenFull(t *testing.T) {
+	p := &connPool{max: 1}
+
+	c1, _ := newTestPooledConn()
+	c2, fc2 := newTestPooledConn()
+	p.put(c1)
+	p.put(c2)
+
+	if !fc2.closed {
+		t.Error("put() should close the incoming connection once the pool is already full")
+	}
+	if len(p.conns) != 1 || p.conns[0] != c1 {
+		t.Error("pool should retain the connection already in it once full")
+	}
+}
+
+func TestConnPoolGetDiscardsStaleConns(t *testing.T) {
+	p := &connPool{max: 2, host: "127.0.0.1:1"}
+
+	stale, fc := newTestPooledConn()
+	stale.idleAt = time.Now().Add(-2 * fastProxyIdleTimeout)
+	p.conns = append(p.conns, stale)
+
+	// No fresh connection behind the stale one and nothing listening on
+	// the dial target, so get() should discard the stale conn and fail
+	// to dial rather than returning the stale one.
+	if _, err := p.get(); err == nil {
+		t.Fatal("expected get() to fail dialing after discarding the stale conn")
+	}
+	if !fc.closed {
+		t.Error("get() should close a connection that has exceeded fastProxyIdleTimeout")
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	upgradeReq := httptest.NewRequest("GET", "http://example.com/ws", nil)
+	upgradeReq.Header.Set("Connection", "Upgrade")
+	plainReq := httptest.NewRequest("GET", "http://example.com/", nil)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		resp *http.Response
+		want bool
+	}{
+		{"switching protocols with upgrade header", upgradeReq, &http.Response{StatusCode: http.StatusSwitchingProtocols}, true},
+		{"switching protocols without upgrade header", plainReq, &http.Response{StatusCode: http.StatusSwitchingProtocols}, false},
+		{"ok status with upgrade header", upgradeReq, &http.Response{StatusCode: http.StatusOK}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUpgrade(tt.req, tt.resp); got != tt.want {
+				t.Errorf("isUpgrade() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// headerFlag collects repeated -header 'Name: value' flags into a slice.
+type headerFlag []string
+
+func (h *headerFlag) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlag) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+var (
+	extraHeaders  headerFlag
+	headerFromEnv = flag.Bool("header-from-env", false, "Read additional 'Name: value' headers to inject from the SHRP_HEADER_* environment variables")
+	stripHeaders  headerFlag
+)
+
+func init() {
+	flag.Var(&extraHeaders, "header", "Header to add to every proxied request, as 'Name: value' (repeatable)")
+	flag.Var(&stripHeaders, "strip-header", "Header name to remove from every proxied request (repeatable)")
+}
+
+// headerRoundTripper mutates req.Header before delegating to wrapped. Unlike
+// setting headers only in a Director, a RoundTripper also sees requests the
+// transport retries or redirects, so the additions apply uniformly.
+type headerRoundTripper struct {
+	wrapped http.RoundTripper
+	add     map[string][]string
+	strip   []string
+}
+
+// newHeaderRoundTripper builds a headerRoundTripper from repeated 'Name:
+// value' add specs and a list of header names to strip.
+func newHeaderRoundTripper(wrapped http.RoundTripper, add []string, strip []string) *headerRoundTripper {
+	h := &headerRoundTripper{wrapped: wrapped, add: map[string][]string{}, strip: strip}
+	for _, spec := range add {
+		name, value, ok := strings.Cut(spec, ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		h.add[name] = append(h.add[name], value)
+	}
+	return h
+}
+
+func (h *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for _, name := range h.strip {
+		req.Header.Del(name)
+	}
+	for name, values := range h.add {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	return h.wrapped.RoundTrip(req)
+}
+
+// headersFromEnv collects 'Name: value' specs from SHRP_HEADER_* environment
+// variables, for operators who'd rather inject auth tokens via the
+// environment than the command line.
+func headersFromEnv() []string {
+	var specs []string
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, "SHRP_HEADER_") {
+			continue
+		}
+		headerName := strings.TrimPrefix(name, "SHRP_HEADER_")
+		specs = append(specs, headerName+": "+value)
+	}
+	return specs
+}
+
+// buildHeaderRoundTripper wraps wrapped with a headerRoundTripper if any
+// -header, -header-from-env, or -strip-header flags were given, otherwise
+// it returns wrapped unchanged.
+func buildHeaderRoundTripper(wrapped http.RoundTripper) http.RoundTripper {
+	add := append([]string{}, extraHeaders...)
+	if *headerFromEnv {
+		add = append(add, headersFromEnv()...)
+	}
+	if len(add) == 0 && len(stripHeaders) == 0 {
+		return wrapped
+	}
+	return newHeaderRoundTripper(wrapped, add, stripHeaders)
+}
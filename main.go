@@ -9,28 +9,31 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/exec"
 )
 
 var (
 	// Define command-line flags
 	hostHeader  = flag.String("host", "", "Value of the Host header to send to the next hop server")
 	nextHop     = flag.String("nexthop", "https://httpbin.org/", "URL of the next hop (target) server")
+	configFile  = flag.String("config", "", "Path to a YAML config defining multiple upstreams and routing rules (overrides -nexthop)")
 	listenAddr  = flag.String("listen", ":8000", "Address to listen on")
 	logRequests = flag.Bool("log", false, "Enable request logging")
+	fastMode    = flag.Bool("fast", false, "Use a hand-rolled HTTP/1.1 proxy engine with a pooled connection cache instead of the stdlib transport (single upstream only)")
+	metricsAddr = flag.String("metrics", "", "Address for a second listener exposing Prometheus metrics, e.g. :9100 (disabled if empty)")
 	flagDaemon  = flag.Bool("daemon", false, "Run as a daemon")
 	flagDebug   = flag.Bool("debug", false, "Enable debug mode")
 	insecureSSL = flag.Bool("insecure", false, "Ignore SSL certificate errors")
 )
 
-func init() {
-	// Parse the flags
+func main() {
+	// Parse the flags. This has to happen in main rather than init so that
+	// `go test` can register its own -test.* flags first.
 	flag.Parse()
 	if *flagDebug {
 		log.SetFlags(log.LstdFlags | log.Lshortfile)
 	}
-}
 
-func main() {
 	// Handle daemonization
 	if *flagDaemon {
 		if !runningAsDaemon() {
@@ -39,12 +42,40 @@ func main() {
 		}
 	}
 
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+
+	// Multi-backend mode: a config file defines several upstreams and the
+	// rules that pick between them, so it takes over from the single
+	// -nexthop/-host handling below.
+	if *configFile != "" {
+		cfg, err := LoadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Invalid config: %v", err)
+		}
+		router, err := NewRouter(cfg)
+		if err != nil {
+			log.Fatalf("Invalid config: %v", err)
+		}
+		fmt.Printf("Starting reverse proxy server on %s, routing via %s\n", *listenAddr, *configFile)
+		log.Fatal(http.ListenAndServe(*listenAddr, instrumentHandler(router)))
+		return
+	}
+
 	// Parse the next hop URL
 	target, err := url.Parse(*nextHop)
 	if err != nil {
 		log.Fatalf("Invalid next hop URL: %v", err)
 	}
 
+	if *fastMode {
+		if err := runFastProxy(*listenAddr, target, *hostHeader, *insecureSSL); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Create a reverse proxy
 	proxy := httputil.NewSingleHostReverseProxy(target)
 
@@ -57,6 +88,20 @@ func main() {
 		}
 	}
 
+	// presbrey/shrp#chunk0-4 ("separate transport for cross-host redirects")
+	// is closed as not applicable to this architecture, not implemented.
+	// ReverseProxy.ServeHTTP calls Transport.RoundTrip exactly once per
+	// incoming request and never itself follows the 3xx it gets back, so a
+	// RoundTripper here never observes a request whose URL.Host differs from
+	// this Director's target: the Director above rewrites req.URL.Host on
+	// every call, before that single RoundTrip. An upstream redirect to a
+	// different host is therefore forwarded to the client as-is, and any
+	// re-dispatch happens outside this process (the client's own next
+	// request), where there is no RoundTripper to split. Fixing the
+	// underlying SNI/Host concern would mean ReverseProxy following
+	// redirects itself and picking a transport per hop, a materially larger
+	// change than the RoundTripper this request asked for.
+
 	// Optionally add request logging
 	if *logRequests {
 		proxy.Transport = &loggingRoundTripper{http.DefaultTransport}
@@ -78,41 +123,43 @@ func main() {
 			log.Printf("%+v", t.TLSClientConfig)
 		}
 	}
-	switch p := proxy.Transport.(type) {
-	case *http.Transport:
-		// Optionally ignore SSL certificate errors
-		if *insecureSSL {
-			if p.TLSClientConfig == nil {
-				p.TLSClientConfig = &tls.Config{}
-			}
-			p.TLSClientConfig.InsecureSkipVerify = true
-		}
-		if *hostHeader != "" {
-			p.TLSClientConfig.ServerName = *hostHeader
-		}
-		if *flagDebug {
-			log.Printf("%+v", p.TLSClientConfig)
-		}
-	case *loggingRoundTripper:
+	if proxy.Transport == nil {
+		proxy.Transport = http.DefaultTransport
+	}
+	if t := underlyingTransport(proxy.Transport); t != nil {
 		// Optionally ignore SSL certificate errors
 		if *insecureSSL {
-			if p.wrapped.(*http.Transport).TLSClientConfig == nil {
-				p.wrapped.(*http.Transport).TLSClientConfig = &tls.Config{}
+			if t.TLSClientConfig == nil {
+				t.TLSClientConfig = &tls.Config{}
 			}
-			p.wrapped.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+			t.TLSClientConfig.InsecureSkipVerify = true
 		}
 		if *hostHeader != "" {
-			p.wrapped.(*http.Transport).TLSClientConfig.ServerName = *hostHeader
+			t.TLSClientConfig.ServerName = *hostHeader
 		}
 		if *flagDebug {
-			log.Printf("%+v", p.wrapped.(*http.Transport).TLSClientConfig)
+			log.Printf("%+v", t.TLSClientConfig)
 		}
 	}
 
+	// Record upstream request metrics before header injection, so the
+	// chain still unwraps down to the *http.Transport for TLS config above.
+	proxy.Transport = &metricsRoundTripper{proxy.Transport}
+
+	// With -insecure, downgrade to plain HTTP on the first attempt when the
+	// outbound proxy env points at a local mitmproxy-style tool.
+	if *insecureSSL {
+		proxy.Transport = &httpFallbackRoundTripper{proxy.Transport}
+	}
+
+	// Layer on header injection/stripping last, so it also applies to
+	// retries and redirects the transport performs on its own.
+	proxy.Transport = buildHeaderRoundTripper(proxy.Transport)
+
 	// Create a handler that will be used to serve all requests
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := instrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		proxy.ServeHTTP(w, r)
-	})
+	}))
 
 	// Start the server
 	fmt.Printf("Starting reverse proxy server on %s, forwarding to %s\n", *listenAddr, *nextHop)
@@ -129,7 +176,47 @@ func (l *loggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 	return l.wrapped.RoundTrip(req)
 }
 
+// Unwrap exposes the wrapped RoundTripper so underlyingTransport can see
+// through decorators like loggingRoundTripper and headerRoundTripper.
+func (l *loggingRoundTripper) Unwrap() http.RoundTripper { return l.wrapped }
+
+// Unwrap exposes the wrapped RoundTripper so underlyingTransport can see
+// through decorators like loggingRoundTripper and headerRoundTripper.
+func (h *headerRoundTripper) Unwrap() http.RoundTripper { return h.wrapped }
+
+// underlyingTransport walks a chain of RoundTripper decorators (anything
+// implementing Unwrap() http.RoundTripper, mirroring the stdlib errors
+// idiom) to find the *http.Transport doing the actual dialing, if any.
+func underlyingTransport(rt http.RoundTripper) *http.Transport {
+	for rt != nil {
+		if t, ok := rt.(*http.Transport); ok {
+			return t
+		}
+		u, ok := rt.(interface{ Unwrap() http.RoundTripper })
+		if !ok {
+			return nil
+		}
+		rt = u.Unwrap()
+	}
+	return nil
+}
+
 // runningAsDaemon checks if the current process is running as a daemon
 func runningAsDaemon() bool {
 	return os.Getenv("FORKED") == "1"
 }
+
+// daemonizeProcess re-execs the current process detached from the
+// controlling terminal, with FORKED=1 set so the child skips straight
+// past the daemonization check in main.
+func daemonizeProcess() {
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), "FORKED=1")
+	cmd.Stdin = nil
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("Failed to daemonize: %v", err)
+	}
+	fmt.Printf("Daemonized process with PID %d\n", cmd.Process.Pid)
+}
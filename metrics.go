@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	upstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shrp",
+		Subsystem: "upstream",
+		Name:      "requests_total",
+		Help:      "Total requests proxied to an upstream, by method, host, and response status class.",
+	}, []string{"method", "host", "code"})
+
+	upstreamInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "shrp",
+		Subsystem: "upstream",
+		Name:      "in_flight_requests",
+		Help:      "Requests currently in flight to an upstream.",
+	})
+
+	upstreamLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "shrp",
+		Subsystem: "upstream",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of proxied requests as observed waiting on the upstream.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "host"})
+
+	upstreamResponseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "shrp",
+		Subsystem: "upstream",
+		Name:      "response_size_bytes",
+		Help:      "Size of the upstream response body, when known from Content-Length.",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 10),
+	}, []string{"method", "host"})
+
+	clientRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shrp",
+		Subsystem: "client",
+		Name:      "requests_total",
+		Help:      "Total requests served to clients, by method and response status class.",
+	}, []string{"method", "code"})
+
+	clientLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "shrp",
+		Subsystem: "client",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests as observed by the client-facing handler.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	clientBytesIn = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "shrp",
+		Subsystem: "client",
+		Name:      "request_bytes_total",
+		Help:      "Total bytes read from client request bodies.",
+	}, []string{"method"})
+)
+
+// metricsRoundTripper wraps a transport to record upstream request count,
+// in-flight gauge, latency, and response size, labeled by method, upstream
+// host, and response status class.
+type metricsRoundTripper struct {
+	wrapped http.RoundTripper
+}
+
+func (m *metricsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	upstreamInFlight.Inc()
+	defer upstreamInFlight.Dec()
+
+	start := time.Now()
+	resp, err := m.wrapped.RoundTrip(req)
+	upstreamLatency.WithLabelValues(req.Method, req.URL.Host).Observe(time.Since(start).Seconds())
+	if err != nil {
+		upstreamRequests.WithLabelValues(req.Method, req.URL.Host, "error").Inc()
+		return resp, err
+	}
+
+	upstreamRequests.WithLabelValues(req.Method, req.URL.Host, statusClass(resp.StatusCode)).Inc()
+	if resp.ContentLength > 0 {
+		upstreamResponseSize.WithLabelValues(req.Method, req.URL.Host).Observe(float64(resp.ContentLength))
+	}
+	return resp, nil
+}
+
+// Unwrap exposes the wrapped RoundTripper so underlyingTransport can see
+// through it to the *http.Transport doing the actual dialing.
+func (m *metricsRoundTripper) Unwrap() http.RoundTripper { return m.wrapped }
+
+// statusClass reduces an HTTP status code to its class, e.g. 404 -> "4xx".
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// instrumentHandler wraps h to record client-observed request count,
+// latency, and bytes-in.
+func instrumentHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > 0 {
+			clientBytesIn.WithLabelValues(r.Method).Add(float64(r.ContentLength))
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		h.ServeHTTP(sw, r)
+		clientLatency.WithLabelValues(r.Method).Observe(time.Since(start).Seconds())
+		clientRequests.WithLabelValues(r.Method, statusClass(sw.status)).Inc()
+	})
+}
+
+// statusWriter captures the status code passed to WriteHeader so it can be
+// reported after the handler returns.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush forwards to the underlying ResponseWriter's http.Flusher, if any, so
+// streamed/chunked responses (SSE, long-poll) keep flushing through
+// instrumentHandler instead of buffering until the handler returns.
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack forwards to the underlying ResponseWriter's http.Hijacker, if any,
+// so Upgrade/websocket responses (which handleUpgradeResponse hijacks) still
+// work through instrumentHandler instead of failing with a non-Hijacker
+// error.
+func (s *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hj.Hijack()
+}
+
+// ReadFrom forwards to the underlying ResponseWriter's io.ReaderFrom, if
+// any, so sendfile-style fast paths aren't lost through instrumentHandler.
+func (s *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := s.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(s.ResponseWriter, r)
+}
+
+// serveMetrics starts a second HTTP listener exposing Prometheus metrics on
+// addr. It runs for the lifetime of the process; a failure to bind is
+// fatal since an operator who asked for -metrics expects it to work.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Printf("Serving metrics on %s", addr)
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, mux))
+	}()
+}
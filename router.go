@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RouteConfig describes one upstream and the rules that select it.
+type RouteConfig struct {
+	Name string `yaml:"name"`
+
+	// Matchers. A rule matches a request when every non-empty matcher on it
+	// is satisfied. An empty RouteConfig.Match matches everything, so put
+	// the catch-all route last.
+	Match struct {
+		Host       string            `yaml:"host"`
+		PathPrefix string            `yaml:"path_prefix"`
+		Method     string            `yaml:"method"`
+		Headers    map[string]string `yaml:"headers"`
+	} `yaml:"match"`
+
+	// Upstream and per-route overrides.
+	Upstream    string            `yaml:"upstream"`
+	HostHeader  string            `yaml:"host_header"`
+	Insecure    bool              `yaml:"insecure"`
+	DialTimeout time.Duration     `yaml:"dial_timeout"`
+	Headers     map[string]string `yaml:"headers"`
+}
+
+// Config is the top-level shape of the `-config` file.
+type Config struct {
+	Routes []RouteConfig `yaml:"routes"`
+}
+
+// LoadConfig reads and parses a YAML routing config from path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	if len(cfg.Routes) == 0 {
+		return nil, fmt.Errorf("config defines no routes")
+	}
+	return &cfg, nil
+}
+
+// backend pairs a route's matcher with the reverse proxy built for it.
+type backend struct {
+	route RouteConfig
+	proxy *httputil.ReverseProxy
+}
+
+// Router dispatches each incoming request to one of several upstreams based
+// on host, path prefix, method, and header matchers, so a single shrp
+// instance can front several origins.
+type Router struct {
+	backends []*backend
+}
+
+// NewRouter builds a Router from cfg, constructing one ReverseProxy per
+// route up front. Routes with identical TLS/proxy/timeout settings share a
+// transport via a TransportCache instead of each minting its own
+// connection pool.
+func NewRouter(cfg *Config) (*Router, error) {
+	transports := NewTransportCache()
+
+	r := &Router{}
+	for _, rt := range cfg.Routes {
+		target, err := url.Parse(rt.Upstream)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: invalid upstream %q: %w", rt.Name, rt.Upstream, err)
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		defaultDirector := proxy.Director
+		proxy.Director = func(req *http.Request) {
+			defaultDirector(req)
+			if rt.HostHeader != "" {
+				req.Host = rt.HostHeader
+			}
+		}
+
+		transport := transports.TransportFor(rt.HostHeader, rt.Insecure, "", rt.DialTimeout)
+		var rtTransport http.RoundTripper = &metricsRoundTripper{transport}
+		if rt.Insecure {
+			rtTransport = &httpFallbackRoundTripper{rtTransport}
+		}
+		if len(rt.Headers) > 0 {
+			var adds []string
+			for k, v := range rt.Headers {
+				adds = append(adds, k+": "+v)
+			}
+			rtTransport = newHeaderRoundTripper(rtTransport, adds, nil)
+		}
+		proxy.Transport = rtTransport
+
+		r.backends = append(r.backends, &backend{route: rt, proxy: proxy})
+	}
+	return r, nil
+}
+
+// match reports whether req satisfies every non-empty matcher on rt.
+func (rt *RouteConfig) match(req *http.Request) bool {
+	m := rt.Match
+	if m.Host != "" && req.Host != m.Host {
+		return false
+	}
+	if m.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, m.PathPrefix) {
+		return false
+	}
+	if m.Method != "" && !strings.EqualFold(req.Method, m.Method) {
+		return false
+	}
+	for k, v := range m.Headers {
+		if req.Header.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP dispatches req to the first backend whose rule matches.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, b := range r.backends {
+		if b.route.match(req) {
+			b.proxy.ServeHTTP(w, req)
+			return
+		}
+	}
+	http.Error(w, "no route matched", http.StatusBadGateway)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteConfigMatch(t *testing.T) {
+	rt := RouteConfig{}
+	rt.Match.Host = "api.example.com"
+	rt.Match.PathPrefix = "/v1/"
+	rt.Match.Method = "POST"
+	rt.Match.Headers = map[string]string{"X-Tenant": "acme"}
+
+	newReq := func(host, path, method string, headers map[string]string) *http.Request {
+		req := httptest.NewRequest(method, "http://"+host+path, nil)
+		req.Host = host
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+
+	matching := map[string]string{"X-Tenant": "acme"}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want bool
+	}{
+		{"all matchers satisfied", newReq("api.example.com", "/v1/widgets", "POST", matching), true},
+		{"wrong host", newReq("other.example.com", "/v1/widgets", "POST", matching), false},
+		{"wrong path prefix", newReq("api.example.com", "/v2/widgets", "POST", matching), false},
+		{"wrong method", newReq("api.example.com", "/v1/widgets", "GET", matching), false},
+		{"missing header", newReq("api.example.com", "/v1/widgets", "POST", nil), false},
+		{"method matches case-insensitively", newReq("api.example.com", "/v1/widgets", "post", matching), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rt.match(tt.req); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteConfigMatchEmptyMatchesEverything(t *testing.T) {
+	rt := RouteConfig{}
+	req := httptest.NewRequest("DELETE", "http://anything.example.com/any/path", nil)
+	if !rt.match(req) {
+		t.Error("an empty Match should match any request")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transportCacheKey canonicalizes the settings that determine whether two
+// routes can safely share one *http.Transport: distinct TLS settings or
+// proxies must never end up on the same connection pool.
+type transportCacheKey struct {
+	serverName  string
+	insecure    bool
+	proxyURL    string
+	dialTimeout time.Duration
+}
+
+// TransportCache hands out a shared *http.Transport per distinct (TLS
+// config, proxy URL, dial timeout, insecure flag) combination, the way
+// client-go's tls-transport cache avoids minting a new connection pool per
+// caller. Without it, per-route TLS settings either leak into a global
+// http.DefaultTransport or force a fresh transport (and connection pool)
+// per request.
+type TransportCache struct {
+	mu    sync.Mutex
+	cache map[transportCacheKey]*http.Transport
+}
+
+// NewTransportCache returns an empty TransportCache ready for use.
+func NewTransportCache() *TransportCache {
+	return &TransportCache{cache: map[transportCacheKey]*http.Transport{}}
+}
+
+// TransportFor returns the shared transport for the given settings,
+// building and caching one on first use.
+func (c *TransportCache) TransportFor(serverName string, insecure bool, proxyURL string, dialTimeout time.Duration) *http.Transport {
+	key := transportCacheKey{serverName: serverName, insecure: insecure, proxyURL: proxyURL, dialTimeout: dialTimeout}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.cache[key]; ok {
+		return t
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure || serverName != "" {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure, ServerName: serverName}
+	}
+	if dialTimeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: dialTimeout}).DialContext
+	}
+	if proxyURL != "" {
+		if u, err := url.Parse(proxyURL); err == nil {
+			t.Proxy = http.ProxyURL(u)
+		}
+	}
+	c.cache[key] = t
+	return t
+}
+
+// httpFallbackRoundTripper downgrades an https:// upstream request to plain
+// http on first attempt when -insecure is set and the outbound proxy
+// resolves to a plain-HTTP localhost proxy, e.g. a mitmproxy-style
+// interception tool running on the operator's machine. If the downgraded
+// attempt fails, it falls back to the original https:// request.
+type httpFallbackRoundTripper struct {
+	wrapped http.RoundTripper
+}
+
+func (h *httpFallbackRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" || !localHTTPProxyConfigured(req) {
+		return h.wrapped.RoundTrip(req)
+	}
+
+	// req.Clone shares req.Body with the original, and the downgraded
+	// attempt's transport may read some or all of it before failing.
+	// Buffer the body up front so the https:// fallback attempt still
+	// gets a complete, unread body if the downgraded attempt fails.
+	var bodyBytes []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		bodyBytes = b
+	}
+
+	downgraded := req.Clone(req.Context())
+	downgraded.URL = new(url.URL)
+	*downgraded.URL = *req.URL
+	downgraded.URL.Scheme = "http"
+	if bodyBytes != nil {
+		downgraded.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	}
+
+	resp, err := h.wrapped.RoundTrip(downgraded)
+	if err == nil {
+		return resp, nil
+	}
+
+	if bodyBytes != nil {
+		req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	}
+	return h.wrapped.RoundTrip(req)
+}
+
+// Unwrap exposes the wrapped RoundTripper so underlyingTransport can see
+// through it to the *http.Transport doing the actual dialing.
+func (h *httpFallbackRoundTripper) Unwrap() http.RoundTripper { return h.wrapped }
+
+// localHTTPProxyConfigured reports whether the environment's HTTPS_PROXY
+// resolution for req points at a plain-HTTP proxy on localhost.
+func localHTTPProxyConfigured(req *http.Request) bool {
+	proxyURL, err := http.ProxyFromEnvironment(req)
+	if err != nil || proxyURL == nil {
+		return false
+	}
+	if proxyURL.Scheme != "http" {
+		return false
+	}
+	host := proxyURL.Hostname()
+	return host == "localhost" || host == "127.0.0.1" || strings.HasPrefix(host, "127.")
+}
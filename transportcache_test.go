@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransportCacheReusesIdenticalSettings(t *testing.T) {
+	c := NewTransportCache()
+
+	t1 := c.TransportFor("api.example.com", false, "", 0)
+	t2 := c.TransportFor("api.example.com", false, "", 0)
+
+	if t1 != t2 {
+		t.Error("TransportFor should return the same *http.Transport for identical settings")
+	}
+}
+
+func TestTransportCacheSeparatesDistinctSettings(t *testing.T) {
+	c := NewTransportCache()
+
+	base := c.TransportFor("api.example.com", false, "", 0)
+
+	cases := []struct {
+		name        string
+		serverName  string
+		insecure    bool
+		proxyURL    string
+		dialTimeout time.Duration
+	}{
+		{"different server name", "other.example.com", false, "", 0},
+		{"insecure flag set", "api.example.com", true, "", 0},
+		{"proxy URL set", "api.example.com", false, "http://127.0.0.1:8080", 0},
+		{"dial timeout set", "api.example.com", false, "", 5 * time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := c.TransportFor(tc.serverName, tc.insecure, tc.proxyURL, tc.dialTimeout)
+			if got == base {
+				t.Error("TransportFor should mint a distinct transport when any setting differs")
+			}
+		})
+	}
+}
+
+func TestTransportCacheAppliesSettings(t *testing.T) {
+	c := NewTransportCache()
+
+	tr := c.TransportFor("api.example.com", true, "", 2*time.Second)
+	if tr.TLSClientConfig == nil {
+		t.Fatal("expected a TLSClientConfig to be set")
+	}
+	if !tr.TLSClientConfig.InsecureSkipVerify {
+		t.Error("expected InsecureSkipVerify to be true")
+	}
+	if tr.TLSClientConfig.ServerName != "api.example.com" {
+		t.Errorf("ServerName = %q, want %q", tr.TLSClientConfig.ServerName, "api.example.com")
+	}
+	if tr.DialContext == nil {
+		t.Error("expected DialContext to be set when a dial timeout is given")
+	}
+}